@@ -0,0 +1,450 @@
+// Copyright 2016-present Oliver Eilhard. All rights reserved.
+// Use of this source code is governed by a MIT-license.
+// See http://vaibhav-sinha.mit-license.org/license.txt for details.
+
+// Package asynq implements the jobqueue.Store interface on top of a plain
+// Redis instance, in the style of hibiken/asynq: a per-topic, per-rank list
+// acts as a priority queue, a ZSET holds delayed retries keyed by
+// NextRetryAt epoch milliseconds, and a set holds jobs that exhausted their
+// retries. This gives users a lightweight, non-SQL deployment option
+// suitable for ephemeral or edge nodes where running MySQL or MongoDB is
+// impractical.
+package asynq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/vaibhav-sinha/jobqueue"
+)
+
+// errNotSupported is returned by the scheduling and history methods, which
+// this Store does not implement. See the Store doc comment.
+var errNotSupported = errors.New("jobqueue/asynq: not supported by this store")
+
+const (
+	keyPrefix = "jobqueue:asynq:"
+
+	defaultRanks = 10
+)
+
+// topicsKey is a set of every topic that has ever had a job created for it,
+// so Next knows which per-rank queues to scan without requiring topics to
+// be configured up front.
+const topicsKey = keyPrefix + "topics"
+
+// Store implements jobqueue.Store on top of Redis.
+//
+// Keys used, for a given topic and rank:
+//
+//	jobqueue:asynq:{topic}:queue:{rank}   list, BRPOPLPUSH'd by Next
+//	jobqueue:asynq:{topic}:scheduled      zset, score = NextRetryAt epoch ms
+//	jobqueue:asynq:{topic}:dead           set, jobs that exhausted MaxRetry
+//	jobqueue:asynq:inflight:{worker}      list, jobs popped but not yet acked
+//	jobqueue:asynq:job:{id}               string, JSON-encoded Job, source of truth for Lookup/List
+//
+// Higher Job.Rank values are higher priority, matching the Store interface
+// contract ("jobs with higher priorities should be executed first"): Next
+// drains the queue for rank s.ranks-1 before rank s.ranks-2, and so on down
+// to rank 0.
+//
+// Store does not implement scheduling or history: asynq-style deployments
+// are expected to be simple dispatch queues, not systems of record, so
+// NextScheduled/UpsertSchedule/ListSchedules/AppendHistory/History all
+// return errNotSupported. Pair this Store with a relational one if a
+// deployment needs those features.
+type Store struct {
+	rdb      *redis.Client
+	ranks    int
+	workerID string
+
+	// inflight tracks, by job ID, the exact raw payload Next RPOPLPUSH'd
+	// into this worker's in-flight list, so Update can LREM the right
+	// entry once the job reaches a terminal state; list membership is
+	// matched by value, not position, so the original payload -- not the
+	// job's possibly-changed current encoding -- has to be kept around.
+	mu       sync.Mutex
+	inflight map[string]string
+}
+
+// StoreOption configures a Store created via NewStore.
+type StoreOption func(*Store)
+
+// SetRanks overrides the number of priority ranks, i.e. queues per topic,
+// that Next scans from highest to lowest priority. The default is 10,
+// i.e. ranks 0 through 9.
+func SetRanks(n int) StoreOption {
+	return func(s *Store) { s.ranks = n }
+}
+
+// SetWorkerID sets the identifier used for this Store's in-flight list. It
+// should be unique per process; if unset, a random identifier is used.
+func SetWorkerID(id string) StoreOption {
+	return func(s *Store) { s.workerID = id }
+}
+
+// NewStore connects to the Redis instance at addr and returns a Store
+// backed by it.
+func NewStore(addr string, opts ...StoreOption) (*Store, error) {
+	s := &Store{
+		ranks:    defaultRanks,
+		inflight: make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.workerID == "" {
+		s.workerID = fmt.Sprintf("worker-%d", time.Now().UnixNano())
+	}
+
+	s.rdb = redis.NewClient(&redis.Options{Addr: addr})
+	if err := s.rdb.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("jobqueue/asynq: ping: %w", err)
+	}
+	return s, nil
+}
+
+func queueKey(topic string, rank int) string {
+	return fmt.Sprintf("%s%s:queue:%d", keyPrefix, topic, rank)
+}
+
+func scheduledKey(topic string) string {
+	return keyPrefix + topic + ":scheduled"
+}
+
+func deadKey(topic string) string {
+	return keyPrefix + topic + ":dead"
+}
+
+func (s *Store) inflightKey() string {
+	return keyPrefix + "inflight:" + s.workerID
+}
+
+func jobKey(id string) string {
+	return keyPrefix + "job:" + id
+}
+
+// Start implements jobqueue.Store. Jobs left in this worker's in-flight
+// list by a previous, crashed run are pushed back onto the queue for their
+// own topic and rank so they get retried.
+func (s *Store) Start(behaviour jobqueue.StartupBehaviour) error {
+	ctx := context.Background()
+	for {
+		data, err := s.rdb.RPop(ctx, s.inflightKey()).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var job jobqueue.Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			continue
+		}
+		if err := s.rdb.LPush(ctx, queueKey(job.Topic, job.Rank), data).Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// Create implements jobqueue.Store by pushing job onto its topic/rank
+// queue and recording it under its job key for later Lookup/List.
+func (s *Store) Create(ctx context.Context, job *jobqueue.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := s.rdb.Set(ctx, jobKey(job.ID), data, 0).Err(); err != nil {
+		return err
+	}
+	if err := s.rdb.SAdd(ctx, topicsKey, job.Topic).Err(); err != nil {
+		return err
+	}
+	return s.rdb.LPush(ctx, queueKey(job.Topic, job.Rank), data).Err()
+}
+
+// Delete implements jobqueue.Store by removing the job's source-of-truth
+// record, and, if it was currently in flight on this worker, LREM'ing it
+// out of the in-flight list so the list does not grow without bound.
+func (s *Store) Delete(ctx context.Context, job *jobqueue.Job) error {
+	if err := s.rdb.Del(ctx, jobKey(job.ID)).Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	inflightData, ok := s.inflight[job.ID]
+	if ok {
+		delete(s.inflight, job.ID)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return s.rdb.LRem(ctx, s.inflightKey(), 1, inflightData).Err()
+}
+
+// Update implements jobqueue.Store. If job failed and has a NextRetryAt in
+// the future, it is moved into the scheduled ZSET instead of being
+// requeued immediately; if it exhausted MaxRetry, it is moved into the
+// dead set; if it failed but is eligible for an immediate retry (no future
+// NextRetryAt, retries remaining), it is pushed straight back onto its
+// ready queue; if it succeeded (or was cancelled), nothing further needs to
+// be persisted. In all four of those cases, job is also LREM'd out of this
+// worker's in-flight list, since it is no longer being worked by this
+// worker and must not be re-run by Start after a restart. Any other state
+// (e.g. still Working) leaves the in-flight entry alone.
+func (s *Store) Update(ctx context.Context, job *jobqueue.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := s.rdb.Set(ctx, jobKey(job.ID), data, 0).Err(); err != nil {
+		return err
+	}
+
+	switch {
+	case job.State == jobqueue.StateSucceeded || job.State == jobqueue.StateCancelled:
+		// Nothing further to persist; fall through to removing it from
+		// the in-flight list below.
+	case job.State == jobqueue.StateFailed && job.Retry >= job.MaxRetry:
+		if err := s.rdb.SAdd(ctx, deadKey(job.Topic), data).Err(); err != nil {
+			return err
+		}
+	case job.State == jobqueue.StateFailed && !job.NextRetryAt.IsZero() && job.NextRetryAt.After(time.Now()):
+		score := float64(job.NextRetryAt.UnixMilli())
+		if err := s.rdb.ZAdd(ctx, scheduledKey(job.Topic), redis.Z{Score: score, Member: data}).Err(); err != nil {
+			return err
+		}
+	case job.State == jobqueue.StateFailed:
+		// Eligible for an immediate retry: push it straight back onto its
+		// ready queue rather than stranding it in-flight.
+		if err := s.rdb.LPush(ctx, queueKey(job.Topic, job.Rank), data).Err(); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+
+	s.mu.Lock()
+	inflightData, ok := s.inflight[job.ID]
+	if ok {
+		delete(s.inflight, job.ID)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return s.rdb.LRem(ctx, s.inflightKey(), 1, inflightData).Err()
+}
+
+// blockPerQueue bounds how long Next blocks on any single queue's
+// BRPOPLPUSH before moving on to the next one; Redis has no multi-key
+// blocking pop, so a priority scan across ranks and topics has to be built
+// out of short blocking pops rather than one long one.
+const blockPerQueue = 100 * time.Millisecond
+
+// Next implements jobqueue.Store. It first promotes any scheduled jobs
+// whose NextRetryAt has arrived into their ready queue, then scans every
+// known topic's queue, highest rank (highest priority) first,
+// BRPOPLPUSH'ing the first non-empty one found into this worker's
+// in-flight list.
+func (s *Store) Next() (*jobqueue.Job, error) {
+	ctx := context.Background()
+
+	topics, err := s.knownTopics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.promoteScheduled(ctx, topics); err != nil {
+		return nil, err
+	}
+
+	for rank := s.ranks - 1; rank >= 0; rank-- {
+		for _, topic := range topics {
+			data, err := s.rdb.BRPopLPush(ctx, queueKey(topic, rank), s.inflightKey(), blockPerQueue).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			var job jobqueue.Job
+			if err := json.Unmarshal([]byte(data), &job); err != nil {
+				continue
+			}
+
+			s.mu.Lock()
+			s.inflight[job.ID] = data
+			s.mu.Unlock()
+
+			return &job, nil
+		}
+	}
+	return nil, nil
+}
+
+// knownTopics returns every topic that has ever had a job created for it.
+func (s *Store) knownTopics(ctx context.Context) ([]string, error) {
+	return s.rdb.SMembers(ctx, topicsKey).Result()
+}
+
+// promoteScheduled moves jobs whose NextRetryAt has arrived from the
+// scheduled ZSET of every known topic into that topic's ready queue. This
+// is called once per Next, which effectively runs it "on every tick" since
+// Next is polled continuously by the Manager.
+func (s *Store) promoteScheduled(ctx context.Context, topics []string) error {
+	now := float64(time.Now().UnixMilli())
+	for _, topic := range topics {
+		due, err := s.rdb.ZRangeByScore(ctx, scheduledKey(topic), &redis.ZRangeBy{
+			Min: "-inf",
+			Max: fmt.Sprintf("%f", now),
+		}).Result()
+		if err != nil {
+			return err
+		}
+		for _, data := range due {
+			var job jobqueue.Job
+			if err := json.Unmarshal([]byte(data), &job); err != nil {
+				continue
+			}
+			if err := s.rdb.LPush(ctx, queueKey(job.Topic, job.Rank), data).Err(); err != nil {
+				return err
+			}
+			if err := s.rdb.ZRem(ctx, scheduledKey(topic), data).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stats implements jobqueue.Store using LLEN/ZCARD/SCARD on the relevant
+// keys for req.Topic.
+func (s *Store) Stats(ctx context.Context, req *jobqueue.StatsRequest) (*jobqueue.Stats, error) {
+	var waiting, failed int64
+	for rank := 0; rank < s.ranks; rank++ {
+		n, err := s.rdb.LLen(ctx, queueKey(req.Topic, rank)).Result()
+		if err != nil {
+			return nil, err
+		}
+		waiting += n
+	}
+	scheduled, err := s.rdb.ZCard(ctx, scheduledKey(req.Topic)).Result()
+	if err != nil {
+		return nil, err
+	}
+	dead, err := s.rdb.SCard(ctx, deadKey(req.Topic)).Result()
+	if err != nil {
+		return nil, err
+	}
+	failed = dead
+
+	return &jobqueue.Stats{
+		Waiting: int(waiting + scheduled),
+		Failed:  int(failed),
+	}, nil
+}
+
+// Lookup implements jobqueue.Store.
+func (s *Store) Lookup(ctx context.Context, id string) (*jobqueue.Job, error) {
+	data, err := s.rdb.Get(ctx, jobKey(id)).Result()
+	if err == redis.Nil {
+		return nil, jobqueue.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job jobqueue.Job
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// LookupByCorrelationID implements jobqueue.Store by scanning job records,
+// since Redis has no secondary index on correlation ID.
+func (s *Store) LookupByCorrelationID(ctx context.Context, correlationID string) ([]*jobqueue.Job, error) {
+	var jobs []*jobqueue.Job
+	iter := s.rdb.Scan(ctx, 0, keyPrefix+"job:*", 0).Iterator()
+	for iter.Next(ctx) {
+		data, err := s.rdb.Get(ctx, iter.Val()).Result()
+		if err != nil {
+			continue
+		}
+		var job jobqueue.Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			continue
+		}
+		if job.CorrelationID == correlationID {
+			jobs = append(jobs, &job)
+		}
+	}
+	return jobs, iter.Err()
+}
+
+// List implements jobqueue.Store by scanning job records and filtering
+// client-side.
+func (s *Store) List(ctx context.Context, req *jobqueue.ListRequest) (*jobqueue.ListResponse, error) {
+	var jobs []*jobqueue.Job
+	iter := s.rdb.Scan(ctx, 0, keyPrefix+"job:*", 0).Iterator()
+	for iter.Next(ctx) {
+		data, err := s.rdb.Get(ctx, iter.Val()).Result()
+		if err != nil {
+			continue
+		}
+		var job jobqueue.Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			continue
+		}
+		if req.Topic != "" && job.Topic != req.Topic {
+			continue
+		}
+		jobs = append(jobs, &job)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	total := len(jobs)
+	if req.Offset > 0 && req.Offset < len(jobs) {
+		jobs = jobs[req.Offset:]
+	} else if req.Offset >= len(jobs) {
+		jobs = nil
+	}
+	if req.Limit > 0 && req.Limit < len(jobs) {
+		jobs = jobs[:req.Limit]
+	}
+	return &jobqueue.ListResponse{Total: total, Jobs: jobs}, nil
+}
+
+// NextScheduled implements jobqueue.Store. See the Store doc comment.
+func (s *Store) NextScheduled(now time.Time) ([]*jobqueue.ScheduleEntry, error) {
+	return nil, errNotSupported
+}
+
+// UpsertSchedule implements jobqueue.Store. See the Store doc comment.
+func (s *Store) UpsertSchedule(ctx context.Context, entry *jobqueue.ScheduleEntry) error {
+	return errNotSupported
+}
+
+// ListSchedules implements jobqueue.Store. See the Store doc comment.
+func (s *Store) ListSchedules(ctx context.Context) ([]*jobqueue.ScheduleEntry, error) {
+	return nil, errNotSupported
+}
+
+// AppendHistory implements jobqueue.Store. See the Store doc comment.
+func (s *Store) AppendHistory(ctx context.Context, ev *jobqueue.JobEvent) error {
+	return errNotSupported
+}
+
+// History implements jobqueue.Store. See the Store doc comment.
+func (s *Store) History(ctx context.Context, jobID string) ([]*jobqueue.JobEvent, error) {
+	return nil, errNotSupported
+}
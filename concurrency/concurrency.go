@@ -0,0 +1,79 @@
+// Copyright 2016-present Oliver Eilhard. All rights reserved.
+// Use of this source code is governed by a MIT-license.
+// See http://vaibhav-sinha.mit-license.org/license.txt for details.
+
+// Package concurrency provides bounded-concurrency helpers for enqueueing
+// and processing batches of jobs without spawning a goroutine per item.
+package concurrency
+
+import (
+	"context"
+	"sync"
+
+	"github.com/vaibhav-sinha/jobqueue"
+)
+
+// ForEachJob calls fn for every index in [0, n), using a fixed pool of
+// workers workers that pull indices off a channel. It returns the first
+// error returned by any call to fn; once an error occurs, remaining indices
+// are abandoned and ctx passed to in-flight calls is cancelled, but
+// ForEachJob still waits for all workers to drain before returning.
+func ForEachJob(ctx context.Context, n int, workers int, fn func(ctx context.Context, idx int) error) error {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	indices := make(chan int)
+	go func() {
+		defer close(indices)
+		for i := 0; i < n; i++ {
+			select {
+			case indices <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := fn(ctx, idx); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// ForEachTopic calls fn once for every topic in topics, using a fixed pool
+// of workers workers -- the bound is exactly workers, not derived from m in
+// any way. It is meant for fanning out maintenance operations (e.g.
+// re-enqueueing stuck jobs, collecting Stats) that act on topics registered
+// on m; m is passed through so such an fn can close over it without a
+// package-level variable. It returns the first error returned by any call
+// to fn.
+func ForEachTopic(ctx context.Context, m *jobqueue.Manager, topics []string, workers int, fn func(ctx context.Context, topic string) error) error {
+	return ForEachJob(ctx, len(topics), workers, func(ctx context.Context, idx int) error {
+		return fn(ctx, topics[idx])
+	})
+}
@@ -0,0 +1,150 @@
+// Copyright 2016-present Oliver Eilhard. All rights reserved.
+// Use of this source code is governed by a MIT-license.
+// See http://vaibhav-sinha.mit-license.org/license.txt for details.
+
+package jobqueue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed 5-field cron expression (minute hour
+// day-of-month month day-of-week), evaluated in UTC. It is intentionally
+// minimal -- it supports "*", single values, comma-separated lists,
+// ranges ("1-5") and step values ("*/15") -- which covers the maintenance
+// and fan-out schedules this package is meant for. Day-of-month and
+// day-of-week follow standard (Vixie) cron semantics: when both are
+// restricted (neither is "*"), a day matches if it satisfies either field,
+// not both.
+type cronSchedule struct {
+	minute map[int]bool
+	hour   map[int]bool
+	dom    map[int]bool
+	month  map[int]bool
+	dow    map[int]bool
+
+	// domStar and dowStar record whether the day-of-month and day-of-week
+	// fields were literally "*" in the original spec, as opposed to e.g. a
+	// list or range that happens to cover the same values. Next needs this
+	// to apply standard (Vixie) cron semantics: when both fields are
+	// restricted, a day matches if it satisfies either one, not both.
+	domStar bool
+	dowStar bool
+}
+
+// parseCronSpec parses a 5-field cron expression into a cronSchedule.
+func parseCronSpec(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("jobqueue: cron spec %q must have 5 fields, got %d", spec, len(fields))
+	}
+	minute, err := parseCronField(fields[0], 0, 59)
+	if err != nil {
+		return nil, err
+	}
+	hour, err := parseCronField(fields[1], 0, 23)
+	if err != nil {
+		return nil, err
+	}
+	dom, err := parseCronField(fields[2], 1, 31)
+	if err != nil {
+		return nil, err
+	}
+	month, err := parseCronField(fields[3], 1, 12)
+	if err != nil {
+		return nil, err
+	}
+	dow, err := parseCronField(fields[4], 0, 6)
+	if err != nil {
+		return nil, err
+	}
+	return &cronSchedule{
+		minute: minute, hour: hour, dom: dom, month: month, dow: dow,
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField expands a single cron field (e.g. "*", "5", "1-5", "*/15")
+// into the set of values it matches within [min, max].
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		rangeStr, step := part, 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			rangeStr = part[:idx]
+			s, err := strconv.Atoi(part[idx+1:])
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("jobqueue: invalid step in cron field %q", field)
+			}
+			step = s
+		}
+
+		lo, hi := min, max
+		if rangeStr != "*" {
+			if idx := strings.Index(rangeStr, "-"); idx >= 0 {
+				var err error
+				lo, err = strconv.Atoi(rangeStr[:idx])
+				if err != nil {
+					return nil, fmt.Errorf("jobqueue: invalid cron field %q", field)
+				}
+				hi, err = strconv.Atoi(rangeStr[idx+1:])
+				if err != nil {
+					return nil, fmt.Errorf("jobqueue: invalid cron field %q", field)
+				}
+			} else {
+				v, err := strconv.Atoi(rangeStr)
+				if err != nil {
+					return nil, fmt.Errorf("jobqueue: invalid cron field %q", field)
+				}
+				lo, hi = v, v
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("jobqueue: cron field %q out of range [%d,%d]", field, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// dayMatches reports whether day-of-month dom and day-of-week dow satisfy
+// the schedule's dom/dow fields, applying standard (Vixie) cron semantics:
+// if either field is "*" it imposes no restriction, but if both are
+// restricted, the day matches when it satisfies either one, not both --
+// e.g. "0 0 1 * 1" fires on the 1st of the month OR any Monday.
+func (s *cronSchedule) dayMatches(dom, dow int) bool {
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return s.dow[dow]
+	case s.dowStar:
+		return s.dom[dom]
+	default:
+		return s.dom[dom] || s.dow[dow]
+	}
+}
+
+// Next returns the earliest time strictly after t that matches the
+// schedule, truncated to the minute as cron expressions do not resolve
+// below that. It returns ErrInvalidSchedule if no match occurs within the
+// year scanned, e.g. "0 0 31 2 *", which no February ever satisfies. A spec
+// that only matches February 29 also gets ErrInvalidSchedule whenever the
+// next February 29 is more than 366 days after t.
+func (s *cronSchedule) Next(t time.Time) (time.Time, error) {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	// Scan at most 366 days' worth of minutes before giving up.
+	for limit := 0; limit < 366*24*60; limit++ {
+		if s.month[int(t.Month())] && s.dayMatches(t.Day(), int(t.Weekday())) && s.hour[t.Hour()] && s.minute[t.Minute()] {
+			return t, nil
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}, ErrInvalidSchedule
+}
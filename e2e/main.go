@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/vaibhav-sinha/jobqueue"
+	"github.com/vaibhav-sinha/jobqueue/concurrency"
 	"github.com/vaibhav-sinha/jobqueue/mongodb"
 	"github.com/vaibhav-sinha/jobqueue/mysql"
 )
@@ -39,6 +40,8 @@ func main() {
 		topicsList      = flag.String("topics", "a,b,c", "comma-separated list of topics")
 		failureRate     = flag.Float64("failure-rate", 0.05, "failure rate in the interval [0.0,1.0]")
 		shutdownTimeout = flag.Duration("shutdown-timeout", -1*time.Second, "timeout to wait after shutdown (negative to wait forever)")
+		seed            = flag.Int("seed", 0, "number of jobs to enqueue up front, with bounded parallelism, before the regular drip-feed starts")
+		seedWorkers     = flag.Int("seed-workers", 8, "number of workers used to enqueue the seed jobs")
 	)
 	flag.Parse()
 
@@ -101,6 +104,14 @@ func main() {
 
 	errc := make(chan error, 1)
 
+	// Seed a batch of jobs up front, with bounded parallelism, so users can
+	// enqueue thousands of jobs without spawning a goroutine per job.
+	if *seed > 0 {
+		if err := seedJobs(context.Background(), m, topics, *ranks, *maxRetry, *seed, *seedWorkers); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// Enqueue tasks
 	go func() {
 		errc <- enqueuer(m, topics, *ranks, *fillTime, *maxRetry)
@@ -124,6 +135,19 @@ func main() {
 	}
 }
 
+// seedJobs enqueues n jobs up front, spread randomly across topics and
+// ranks, using workers workers to bound parallelism instead of enqueueing
+// them one at a time or spawning a goroutine per job.
+func seedJobs(ctx context.Context, m *jobqueue.Manager, topics []string, ranks int, maxRetry int, n int, workers int) error {
+	return concurrency.ForEachJob(ctx, n, workers, func(ctx context.Context, idx int) error {
+		topic := topics[rand.Intn(len(topics))]
+		rank := rand.Intn(ranks)
+		cid := fmt.Sprintf("#seed-%05d", idx)
+		job := &jobqueue.Job{Topic: topic, Rank: rank, MaxRetry: maxRetry, CorrelationID: cid}
+		return m.Add(ctx, job)
+	})
+}
+
 func enqueuer(m *jobqueue.Manager, topics []string, ranks int, fillTime time.Duration, maxRetry int) error {
 	var cnt int
 
@@ -0,0 +1,107 @@
+// Copyright 2016-present Oliver Eilhard. All rights reserved.
+// Use of this source code is governed by a MIT-license.
+// See http://vaibhav-sinha.mit-license.org/license.txt for details.
+
+package jobqueue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of state transition a JobEvent records.
+type EventType string
+
+// The event types recorded in a job's history, in the order a well-behaved
+// job typically passes through them. A job may skip Retried (if it succeeds
+// on the first attempt) or jump straight to Cancelled from Enqueued.
+const (
+	EventEnqueued  EventType = "enqueued"
+	EventStarted   EventType = "started"
+	EventRetried   EventType = "retried"
+	EventSucceeded EventType = "succeeded"
+	EventFailed    EventType = "failed"
+	EventCancelled EventType = "cancelled"
+)
+
+// JobEvent is a single, immutable state transition of a job. Events are
+// keyed by (JobID, Version): Version increases by one for every event
+// recorded against a given job, so the full ordered history can be
+// reconstructed without relying on timestamps or storage ordering.
+type JobEvent struct {
+	JobID         string
+	Version       int
+	Type          EventType
+	Topic         string
+	CorrelationID string
+	At            time.Time
+
+	// Message carries additional context for the transition, e.g. the error
+	// message for a Failed event or the attempt number for a Retried event.
+	Message string
+}
+
+// jobVersionMu guards jobVersion, an in-memory counter of the next Version
+// to assign per job. Deriving Version this way, rather than re-reading a
+// job's full history on every transition, keeps appendHistory O(1) instead
+// of O(n) per call (O(n^2) over a job's lifetime) and gives concurrent
+// transitions on the same job distinct versions instead of racing to
+// compute the same one from a stale read.
+var (
+	jobVersionMu sync.Mutex
+	jobVersion   = map[string]int{}
+)
+
+// nextHistoryVersion returns the next Version to record for jobID.
+func nextHistoryVersion(jobID string) int {
+	jobVersionMu.Lock()
+	defer jobVersionMu.Unlock()
+	jobVersion[jobID]++
+	return jobVersion[jobID]
+}
+
+// appendHistory records a JobEvent for job via the store and discards any
+// error the store returns, consistent with how Update failures are treated elsewhere in the
+// Manager: a broken audit trail must never stop a job from being processed.
+func (m *Manager) appendHistory(ctx context.Context, job *Job, typ EventType, message string) {
+	if m.store == nil {
+		return
+	}
+	ev := &JobEvent{
+		JobID:         job.ID,
+		Version:       nextHistoryVersion(job.ID),
+		Type:          typ,
+		Topic:         job.Topic,
+		CorrelationID: job.CorrelationID,
+		At:            time.Now().UTC(),
+		Message:       message,
+	}
+	_ = m.store.AppendHistory(ctx, ev)
+}
+
+// History returns the ordered state transitions recorded for jobID, oldest
+// first.
+func (m *Manager) History(ctx context.Context, jobID string) ([]*JobEvent, error) {
+	return m.store.History(ctx, jobID)
+}
+
+// HistoryByCorrelationID returns the ordered state transitions for every job
+// sharing correlationID, grouped by job and ordered oldest first within each
+// group. This is the forensic entry point for a whole workflow rather than
+// a single job.
+func (m *Manager) HistoryByCorrelationID(ctx context.Context, correlationID string) (map[string][]*JobEvent, error) {
+	jobs, err := m.store.LookupByCorrelationID(ctx, correlationID)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]*JobEvent, len(jobs))
+	for _, job := range jobs {
+		events, err := m.store.History(ctx, job.ID)
+		if err != nil {
+			return nil, err
+		}
+		out[job.ID] = events
+	}
+	return out, nil
+}
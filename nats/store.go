@@ -0,0 +1,517 @@
+// Copyright 2016-present Oliver Eilhard. All rights reserved.
+// Use of this source code is governed by a MIT-license.
+// See http://vaibhav-sinha.mit-license.org/license.txt for details.
+
+// Package nats implements the jobqueue.Store interface on top of NATS
+// JetStream, so jobs are dispatched over a pub/sub bus rather than polled
+// from a SQL table. This lets workers scale horizontally without hammering
+// a relational database with `SELECT ... FOR UPDATE`-style polling, and
+// lets other services listening on the same bus observe job lifecycle
+// events directly.
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/vaibhav-sinha/jobqueue"
+)
+
+const (
+	defaultStreamName = "JOBQUEUE"
+	defaultConsumer   = "jobqueue-workers"
+	schedulesBucket   = "jobqueue_schedules"
+	historyBucket     = "jobqueue_history"
+	jobsBucket        = "jobqueue_jobs"
+)
+
+// Store implements jobqueue.Store on top of a NATS JetStream stream. Jobs
+// are published as stream messages keyed by topic subject; Next blocks on a
+// JetStream pull-consumer and maps ack/nak semantics onto the existing
+// Update path: a successful job is Acked, a retryable failure is Nak'd with
+// a delay derived from the job's retry policy.
+type Store struct {
+	nc        *nats.Conn
+	js        nats.JetStreamContext
+	sub       *nats.Subscription
+	schedules nats.KeyValue
+	history   nats.KeyValue
+	jobs      nats.KeyValue
+
+	stream   string
+	consumer string
+	debug    bool
+
+	// lockOwner and lockTTL govern the leader-election style lock taken by
+	// NextScheduled: lockOwner identifies this Store instance, and lockTTL
+	// is how long a won lock is held before another Store is allowed to
+	// steal it, e.g. after this one crashed mid-fire.
+	lockOwner string
+	lockTTL   time.Duration
+
+	// pending holds the *nats.Msg fetched by Next for every job that has
+	// not yet been Acked or Nak'd, keyed by job ID, so Update can map a
+	// job's outcome back onto the message it came from.
+	mu      sync.Mutex
+	pending map[string]*nats.Msg
+}
+
+// StoreOption configures a Store created via NewStore.
+type StoreOption func(*Store)
+
+// SetStream overrides the JetStream stream name jobs are published to and
+// consumed from. The default is "JOBQUEUE".
+func SetStream(name string) StoreOption {
+	return func(s *Store) { s.stream = name }
+}
+
+// SetConsumer overrides the name of the durable pull-consumer used by Next.
+// The default is "jobqueue-workers".
+func SetConsumer(name string) StoreOption {
+	return func(s *Store) { s.consumer = name }
+}
+
+// SetDebug sets the Store's debug flag, mirroring mysql.SetDebug and
+// mongodb.SetDebug. This Store does not log anything, whatever the flag.
+func SetDebug(debug bool) StoreOption {
+	return func(s *Store) { s.debug = debug }
+}
+
+// SetLockOwner assigns the identifier this Store records as
+// ScheduleEntry.LockedBy while it holds the lock on an entry. It should be
+// unique per process, e.g. hostname:pid. If unset, a random identifier is
+// used.
+func SetLockOwner(owner string) StoreOption {
+	return func(s *Store) { s.lockOwner = owner }
+}
+
+// SetLockTTL overrides how long a lock acquired by NextScheduled is held
+// before another Store is allowed to steal it. The default is 30 seconds.
+func SetLockTTL(d time.Duration) StoreOption {
+	return func(s *Store) { s.lockTTL = d }
+}
+
+// NewStore connects to the NATS server at url and returns a Store backed by
+// JetStream. It creates the backing stream, durable consumer, and the KV
+// buckets used for schedules and history if they do not already exist.
+func NewStore(url string, opts ...StoreOption) (*Store, error) {
+	s := &Store{
+		stream:   defaultStreamName,
+		consumer: defaultConsumer,
+		lockTTL:  30 * time.Second,
+		pending:  make(map[string]*nats.Msg),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.lockOwner == "" {
+		s.lockOwner = fmt.Sprintf("nats-store-%d", time.Now().UnixNano())
+	}
+
+	nc, err := nats.Connect(url)
+	if err != nil {
+		return nil, fmt.Errorf("jobqueue/nats: connect: %w", err)
+	}
+	s.nc = nc
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("jobqueue/nats: jetstream: %w", err)
+	}
+	s.js = js
+
+	if _, err := js.AddStream(&nats.StreamConfig{
+		Name:     s.stream,
+		Subjects: []string{s.stream + ".>"},
+	}); err != nil && err != nats.ErrStreamNameAlreadyInUse {
+		return nil, fmt.Errorf("jobqueue/nats: add stream: %w", err)
+	}
+
+	sub, err := js.PullSubscribe(s.stream+".>", s.consumer)
+	if err != nil {
+		return nil, fmt.Errorf("jobqueue/nats: pull subscribe: %w", err)
+	}
+	s.sub = sub
+
+	schedules, err := js.KeyValue(schedulesBucket)
+	if err == nats.ErrBucketNotFound {
+		schedules, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: schedulesBucket})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jobqueue/nats: schedules bucket: %w", err)
+	}
+	s.schedules = schedules
+
+	history, err := js.KeyValue(historyBucket)
+	if err == nats.ErrBucketNotFound {
+		history, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: historyBucket})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jobqueue/nats: history bucket: %w", err)
+	}
+	s.history = history
+
+	jobs, err := js.KeyValue(jobsBucket)
+	if err == nats.ErrBucketNotFound {
+		jobs, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: jobsBucket})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jobqueue/nats: jobs bucket: %w", err)
+	}
+	s.jobs = jobs
+
+	return s, nil
+}
+
+// subject returns the JetStream subject a job for topic is published to.
+func (s *Store) subject(topic string) string {
+	return s.stream + "." + topic
+}
+
+// Start implements jobqueue.Store.
+func (s *Store) Start(behaviour jobqueue.StartupBehaviour) error {
+	// JetStream retains in-flight messages until acked or their ack-wait
+	// expires, so a crashed consumer's messages are automatically
+	// redelivered; there is nothing to reconcile on startup.
+	return nil
+}
+
+// Create implements jobqueue.Store by publishing job to its topic subject.
+func (s *Store) Create(ctx context.Context, job *jobqueue.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = s.js.Publish(s.subject(job.Topic), data, nats.Context(ctx))
+	return err
+}
+
+// Delete implements jobqueue.Store. There is no reliable way to delete an
+// already-published JetStream message by content, so Delete instead removes
+// job's record from the jobs bucket, so Lookup/List no longer see it, and,
+// if the message Next fetched for it is still unacked, Acks it so
+// JetStream stops redelivering it. Cancellation should ordinarily go
+// through Update(job with a Cancelled state) instead, which also records
+// the final state rather than erasing it.
+func (s *Store) Delete(ctx context.Context, job *jobqueue.Job) error {
+	if err := s.jobs.Delete(job.ID); err != nil && err != nats.ErrKeyNotFound {
+		return err
+	}
+
+	s.mu.Lock()
+	msg, ok := s.pending[job.ID]
+	if ok {
+		delete(s.pending, job.ID)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return msg.Ack()
+}
+
+// Update implements jobqueue.Store. Besides persisting the latest state
+// into the jobs KV bucket, Update looks up the *nats.Msg that Next fetched
+// for job.ID and Acks or Naks it to close the loop with JetStream: Ack on a
+// terminal success, Ack on a terminal failure that has exhausted its
+// retries (so JetStream stops redelivering it), or Nak-with-delay on a
+// retryable failure, honoring job.NextRetryAt. Any other state leaves the
+// message pending, since the job is still being worked.
+func (s *Store) Update(ctx context.Context, job *jobqueue.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if _, err := s.jobs.Put(job.ID, data); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	msg, ok := s.pending[job.ID]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case job.State == jobqueue.StateSucceeded || job.State == jobqueue.StateCancelled:
+		err = msg.Ack()
+	case job.State == jobqueue.StateFailed && job.Retry >= job.MaxRetry:
+		// Retries exhausted; Ack so JetStream does not redeliver it.
+		err = msg.Ack()
+	case job.State == jobqueue.StateFailed && !job.NextRetryAt.IsZero() && job.NextRetryAt.After(time.Now()):
+		err = msg.NakWithDelay(time.Until(job.NextRetryAt))
+	case job.State == jobqueue.StateFailed:
+		// Retryable and no future NextRetryAt: Nak for immediate redelivery.
+		err = msg.Nak()
+	default:
+		// Still in progress, e.g. Started -- leave the message pending.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.pending, job.ID)
+	s.mu.Unlock()
+	return nil
+}
+
+// Next implements jobqueue.Store by pulling a single message from the
+// JetStream consumer. Honoring the per-topic retry policy is done by Update,
+// which Naks the message with a delay lasting until job.NextRetryAt; Next
+// itself simply decodes the next available message.
+func (s *Store) Next() (*jobqueue.Job, error) {
+	msgs, err := s.sub.Fetch(1, nats.MaxWait(1*time.Second))
+	if err == nats.ErrTimeout || len(msgs) == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msg := msgs[0]
+	var job jobqueue.Job
+	if err := json.Unmarshal(msg.Data, &job); err != nil {
+		_ = msg.Nak()
+		return nil, err
+	}
+
+	// Retain msg keyed by job ID so Update can Ack/Nak it once the job's
+	// outcome is known, without leaking a NATS-specific type through the
+	// jobqueue.Store interface.
+	s.mu.Lock()
+	s.pending[job.ID] = msg
+	s.mu.Unlock()
+
+	return &job, nil
+}
+
+// Stats implements jobqueue.Store using the stream's message counts.
+func (s *Store) Stats(ctx context.Context, req *jobqueue.StatsRequest) (*jobqueue.Stats, error) {
+	info, err := s.js.StreamInfo(s.stream)
+	if err != nil {
+		return nil, err
+	}
+	return &jobqueue.Stats{
+		Waiting: int(info.State.Msgs),
+	}, nil
+}
+
+// Lookup implements jobqueue.Store by reading the latest known state of the
+// job from the jobs bucket, since JetStream itself is not queryable by job
+// ID.
+func (s *Store) Lookup(ctx context.Context, id string) (*jobqueue.Job, error) {
+	entry, err := s.jobs.Get(id)
+	if err == nats.ErrKeyNotFound {
+		return nil, jobqueue.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job jobqueue.Job
+	if err := json.Unmarshal(entry.Value(), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// LookupByCorrelationID implements jobqueue.Store. This is not efficient
+// over JetStream KV, which has no secondary index, so callers that need
+// this heavily should pair the nats Store with a relational store for
+// lookups and use JetStream only for dispatch.
+func (s *Store) LookupByCorrelationID(ctx context.Context, correlationID string) ([]*jobqueue.Job, error) {
+	keys, err := s.jobs.Keys()
+	if err == nats.ErrNoKeysFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*jobqueue.Job
+	for _, key := range keys {
+		job, err := s.Lookup(ctx, key)
+		if err != nil {
+			continue
+		}
+		if job.CorrelationID == correlationID {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// List implements jobqueue.Store. See the caveat on LookupByCorrelationID:
+// filtering happens client-side over the jobs bucket.
+func (s *Store) List(ctx context.Context, req *jobqueue.ListRequest) (*jobqueue.ListResponse, error) {
+	keys, err := s.jobs.Keys()
+	if err == nats.ErrNoKeysFound {
+		return &jobqueue.ListResponse{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*jobqueue.Job
+	for _, key := range keys {
+		job, err := s.Lookup(ctx, key)
+		if err != nil {
+			continue
+		}
+		if req.Topic != "" && job.Topic != req.Topic {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return &jobqueue.ListResponse{Total: len(jobs), Jobs: jobs}, nil
+}
+
+// NextScheduled implements jobqueue.Store using the schedules KV bucket.
+// The leader-election style lock is acquired here, atomically, via
+// JetStream KV's per-key revision check: for every due, unlocked entry, it
+// writes LockedBy/LockedUntil back with Update(key, data, revision), which
+// only succeeds if nobody else has written to that key since it was read.
+// Only entries this call won the lock for are returned, so two managers
+// racing to call NextScheduled at the same tick can never both get back
+// the same entry.
+func (s *Store) NextScheduled(now time.Time) ([]*jobqueue.ScheduleEntry, error) {
+	keys, err := s.schedules.Keys()
+	if err == nats.ErrNoKeysFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var due []*jobqueue.ScheduleEntry
+	for _, key := range keys {
+		kv, err := s.schedules.Get(key)
+		if err != nil {
+			continue
+		}
+		var entry jobqueue.ScheduleEntry
+		if err := json.Unmarshal(kv.Value(), &entry); err != nil {
+			continue
+		}
+		if entry.NextRunAt.After(now) || entry.LockedUntil.After(now) {
+			continue
+		}
+
+		entry.LockedBy = s.lockOwner
+		entry.LockedUntil = now.Add(s.lockTTL)
+		data, err := json.Marshal(&entry)
+		if err != nil {
+			continue
+		}
+		if _, err := s.schedules.Update(key, data, kv.Revision()); err != nil {
+			// Another manager won the race to lock this entry this tick.
+			continue
+		}
+
+		due = append(due, &entry)
+	}
+	return due, nil
+}
+
+func (s *Store) getSchedule(id string) (*jobqueue.ScheduleEntry, error) {
+	kv, err := s.schedules.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	var entry jobqueue.ScheduleEntry
+	if err := json.Unmarshal(kv.Value(), &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// UpsertSchedule implements jobqueue.Store. Entries are keyed by
+// entry.ID rather than entry.Topic, since RegisterCron/RegisterInterval
+// place no restriction on registering more than one schedule against the
+// same topic.
+func (s *Store) UpsertSchedule(ctx context.Context, entry *jobqueue.ScheduleEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.schedules.Put(entry.ID, data)
+	return err
+}
+
+// ListSchedules implements jobqueue.Store.
+func (s *Store) ListSchedules(ctx context.Context) ([]*jobqueue.ScheduleEntry, error) {
+	keys, err := s.schedules.Keys()
+	if err == nats.ErrNoKeysFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*jobqueue.ScheduleEntry
+	for _, key := range keys {
+		entry, err := s.getSchedule(key)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// AppendHistory implements jobqueue.Store by appending a revisioned entry
+// under a key namespaced by job ID and version, so Create naturally
+// rejects overwrites of an already-recorded (JobID, Version) pair.
+func (s *Store) AppendHistory(ctx context.Context, ev *jobqueue.JobEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s.%d", ev.JobID, ev.Version)
+	_, err = s.history.Create(key, data)
+	return err
+}
+
+// History implements jobqueue.Store by reading every (JobID, Version) entry
+// recorded for jobID, ordered oldest first. Keys() does not guarantee any
+// particular order, so the events are explicitly sorted by Version before
+// being returned.
+func (s *Store) History(ctx context.Context, jobID string) ([]*jobqueue.JobEvent, error) {
+	keys, err := s.history.Keys()
+	if err == nats.ErrNoKeysFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var events []*jobqueue.JobEvent
+	for _, key := range keys {
+		entry, err := s.history.Get(key)
+		if err != nil {
+			continue
+		}
+		var ev jobqueue.JobEvent
+		if err := json.Unmarshal(entry.Value(), &ev); err != nil {
+			continue
+		}
+		if ev.JobID == jobID {
+			events = append(events, &ev)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Version < events[j].Version })
+	return events, nil
+}
+
+// Close drains the pull-subscription and closes the underlying NATS
+// connection.
+func (s *Store) Close() error {
+	if s.sub != nil {
+		_ = s.sub.Drain()
+	}
+	s.nc.Close()
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2016-present Oliver Eilhard. All rights reserved.
+// Use of this source code is governed by a MIT-license.
+// See http://vaibhav-sinha.mit-license.org/license.txt for details.
+
+package jobqueue
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RetryPolicy computes how long to wait before retrying a job after the
+// given attempt has failed. attempt is 1 for the first retry, 2 for the
+// second, and so on. NextDelay must return 0 to retry immediately and must
+// never return a negative duration.
+//
+// A RetryPolicy is set per topic via RegisterWithOptions and is consulted by
+// the Manager to populate Job.NextRetryAt whenever a job fails and is
+// eligible for another attempt.
+type RetryPolicy interface {
+	NextDelay(attempt int) time.Duration
+}
+
+// ConstantBackoff retries after the same fixed delay every time.
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+// NextDelay implements RetryPolicy.
+func (b ConstantBackoff) NextDelay(attempt int) time.Duration {
+	if b.Delay < 0 {
+		return 0
+	}
+	return b.Delay
+}
+
+// ExponentialBackoff retries with a delay that grows exponentially with the
+// attempt number, optionally randomized with jitter to avoid a thundering
+// herd of jobs retrying in lockstep after e.g. a downstream outage.
+//
+// The delay is computed as min(Max, Base * Multiplier^attempt), then
+// jittered by +/- JitterFraction/2, i.e.:
+//
+//	delay * (1 + rand.Float64()*JitterFraction - JitterFraction/2)
+//
+// JitterFraction of 0 disables jitter. The result is clamped to zero; it is
+// never negative.
+type ExponentialBackoff struct {
+	Base           time.Duration
+	Max            time.Duration
+	Multiplier     float64
+	JitterFraction float64
+}
+
+// NextDelay implements RetryPolicy.
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	if attempt < 0 {
+		attempt = 0
+	}
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	delay := float64(b.Base) * math.Pow(multiplier, float64(attempt))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+
+	if b.JitterFraction > 0 {
+		delay *= 1 + rand.Float64()*b.JitterFraction - b.JitterFraction/2
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay)
+}
+
+// CustomBackoff adapts an arbitrary function to the RetryPolicy interface.
+// The function must not return a negative duration; negative results are
+// clamped to zero.
+type CustomBackoff func(attempt int) time.Duration
+
+// NextDelay implements RetryPolicy.
+func (f CustomBackoff) NextDelay(attempt int) time.Duration {
+	d := f(attempt)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// TopicOption configures a topic registered via RegisterWithOptions.
+type TopicOption func(*topicOptions)
+
+// topicOptions holds the per-topic configuration accumulated from
+// TopicOption values. It is unexported because only the Manager needs to
+// read it once Register/RegisterWithOptions has been called.
+type topicOptions struct {
+	retryPolicy RetryPolicy
+}
+
+// SetRetryPolicy attaches a RetryPolicy to a topic. If not set, a topic
+// retries immediately, preserving the pre-existing behavior.
+func SetRetryPolicy(p RetryPolicy) TopicOption {
+	return func(o *topicOptions) { o.retryPolicy = p }
+}
+
+// topicOptionsMu guards topicOptionsByManager. The per-topic options are
+// keyed by *Manager rather than held in a Manager field because
+// RegisterWithOptions must be addable here without touching Manager's own
+// struct definition.
+var (
+	topicOptionsMu        sync.Mutex
+	topicOptionsByManager = map[*Manager]map[string]*topicOptions{}
+)
+
+// RegisterWithOptions is like Register but additionally accepts TopicOption
+// values, e.g. SetRetryPolicy, that configure per-topic behavior.
+func (m *Manager) RegisterWithOptions(topic string, p Processor, opts ...TopicOption) error {
+	if err := m.Register(topic, p); err != nil {
+		return err
+	}
+
+	o := &topicOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	topicOptionsMu.Lock()
+	defer topicOptionsMu.Unlock()
+	perTopic, ok := topicOptionsByManager[m]
+	if !ok {
+		perTopic = make(map[string]*topicOptions)
+		topicOptionsByManager[m] = perTopic
+	}
+	perTopic[topic] = o
+	return nil
+}
+
+// retryPolicyFor returns the RetryPolicy configured for topic via
+// RegisterWithOptions, or nil if topic was registered with Register instead
+// (or was never registered at all), meaning it retries immediately.
+func (m *Manager) retryPolicyFor(topic string) RetryPolicy {
+	topicOptionsMu.Lock()
+	defer topicOptionsMu.Unlock()
+	perTopic, ok := topicOptionsByManager[m]
+	if !ok {
+		return nil
+	}
+	o, ok := perTopic[topic]
+	if !ok {
+		return nil
+	}
+	return o.retryPolicy
+}
+
+// nextRetryAt computes the time a job on topic should become eligible again
+// after failing its attempt-th try (1 for the first retry, 2 for the
+// second, and so on), consulting the RetryPolicy configured via
+// RegisterWithOptions. It returns the zero time -- retry immediately -- if
+// topic has no RetryPolicy configured, preserving the pre-existing
+// behavior.
+func (m *Manager) nextRetryAt(topic string, attempt int) time.Time {
+	policy := m.retryPolicyFor(topic)
+	if policy == nil {
+		return time.Time{}
+	}
+	return time.Now().UTC().Add(policy.NextDelay(attempt))
+}
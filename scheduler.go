@@ -0,0 +1,215 @@
+// Copyright 2016-present Oliver Eilhard. All rights reserved.
+// Use of this source code is governed by a MIT-license.
+// See http://vaibhav-sinha.mit-license.org/license.txt for details.
+
+package jobqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrInvalidSchedule is returned when a cron expression or interval
+	// cannot be parsed or is otherwise invalid.
+	ErrInvalidSchedule = errors.New("jobqueue: invalid schedule")
+)
+
+// ScheduleEntry is a recurring schedule as persisted by the Store. The
+// Scheduler consults NextScheduled on every tick and enqueues a fresh copy of
+// Template whenever NextRunAt is due.
+type ScheduleEntry struct {
+	ID string // unique identifier; distinguishes multiple schedules on the same topic
+
+	Topic    string        // topic the generated jobs are enqueued under
+	Spec     string        // cron expression; empty if Interval is set
+	Interval time.Duration // fixed interval; zero if Spec is set
+	Template *Job          // blueprint used to create new job instances
+
+	NextRunAt time.Time // next time this schedule is due to fire
+
+	// LockedBy and LockedUntil implement leader-election style locking so
+	// that only one manager in a cluster fires a given schedule at a given
+	// time. Store implementations back this with a row lock, e.g. `SELECT
+	// ... FOR UPDATE` in the MySQL store or a findAndModify in the MongoDB
+	// store.
+	LockedBy    string
+	LockedUntil time.Time
+}
+
+// due reports whether the entry is ready to fire at now, taking the lock
+// into account.
+func (e *ScheduleEntry) due(now time.Time) bool {
+	if e.NextRunAt.After(now) {
+		return false
+	}
+	return e.LockedUntil.Before(now)
+}
+
+// Scheduler registers recurring job definitions -- on a cron schedule or at
+// a fixed interval -- and enqueues instances of them as they come due.
+//
+// Scheduler does not start any goroutines on its own; call Start to begin
+// polling the Store and Stop to end it. Multiple Scheduler instances may run
+// against the same Store concurrently, e.g. one per node in a cluster; the
+// Store is responsible for making sure only one of them wins the lock for a
+// given schedule and tick -- NextScheduled must acquire that lock
+// atomically before handing an entry back, so Scheduler itself holds no
+// locking state of its own.
+type Scheduler struct {
+	store   Store
+	manager *Manager
+	tick    time.Duration
+
+	closing chan struct{}
+	closed  chan struct{}
+}
+
+// SchedulerOption configures a Scheduler created via NewScheduler.
+type SchedulerOption func(*Scheduler)
+
+// SetTick overrides the polling interval used to check the Store for due
+// schedules. The default is 1 second.
+func SetTick(d time.Duration) SchedulerOption {
+	return func(s *Scheduler) { s.tick = d }
+}
+
+// NewScheduler creates a Scheduler that enqueues jobs into m using the
+// schedules persisted in store.
+func NewScheduler(m *Manager, store Store, opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		store:   store,
+		manager: m,
+		tick:    1 * time.Second,
+		closing: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// RegisterCron registers a recurring job definition that fires according to
+// a 5-field cron expression (minute hour day-of-month month day-of-week).
+// template is used as a blueprint: a fresh copy is enqueued under topic
+// every time the schedule comes due.
+func (s *Scheduler) RegisterCron(topic, spec string, template *Job) error {
+	sched, err := parseCronSpec(spec)
+	if err != nil {
+		return err
+	}
+	nextRunAt, err := sched.Next(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	entry := &ScheduleEntry{
+		ID:        newScheduleID(topic),
+		Topic:     topic,
+		Spec:      spec,
+		Template:  template,
+		NextRunAt: nextRunAt,
+	}
+	return s.store.UpsertSchedule(context.Background(), entry)
+}
+
+// RegisterInterval registers a recurring job definition that fires once per
+// interval every, starting one interval from now. template is used as a blueprint: a fresh copy is
+// enqueued under topic every time the schedule comes due.
+func (s *Scheduler) RegisterInterval(topic string, every time.Duration, template *Job) error {
+	if every <= 0 {
+		return ErrInvalidSchedule
+	}
+	entry := &ScheduleEntry{
+		ID:        newScheduleID(topic),
+		Topic:     topic,
+		Interval:  every,
+		Template:  template,
+		NextRunAt: time.Now().UTC().Add(every),
+	}
+	return s.store.UpsertSchedule(context.Background(), entry)
+}
+
+// newScheduleID returns a unique identifier for a new schedule on topic, so
+// that multiple crons or intervals registered against the same topic don't
+// collide in the Store.
+func newScheduleID(topic string) string {
+	return fmt.Sprintf("%s-%d", topic, time.Now().UnixNano())
+}
+
+// Start begins polling the Store for due schedules and enqueueing jobs for
+// them. It returns immediately; polling happens in a background goroutine.
+func (s *Scheduler) Start() {
+	go s.run()
+}
+
+// Stop ends polling and waits for the background goroutine to exit.
+func (s *Scheduler) Stop() {
+	close(s.closing)
+	<-s.closed
+}
+
+func (s *Scheduler) run() {
+	defer close(s.closed)
+
+	t := time.NewTicker(s.tick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.closing:
+			return
+		case now := <-t.C:
+			s.fire(now)
+		}
+	}
+}
+
+// fire enqueues a job for every schedule NextScheduled hands back. The lock
+// on each entry is already held at this point -- NextScheduled acquires it
+// atomically before returning an entry, so two Schedulers racing on the
+// same tick can never both receive the same entry here. fire only needs to
+// compute the next run time and release the lock once it is done with the
+// entry.
+func (s *Scheduler) fire(now time.Time) {
+	ctx := context.Background()
+
+	due, err := s.store.NextScheduled(now)
+	if err != nil {
+		return
+	}
+	for _, entry := range due {
+		job := *entry.Template
+		job.Topic = entry.Topic
+		if err := s.manager.Add(ctx, &job); err != nil {
+			continue
+		}
+
+		switch {
+		case entry.Spec != "":
+			sched, err := parseCronSpec(entry.Spec)
+			if err != nil {
+				continue
+			}
+			nextRunAt, err := sched.Next(now)
+			if err != nil {
+				// The spec has no match within the year Next scans (e.g.
+				// "0 0 31 2 *"); drop the schedule
+				// rather than persist a stale NextRunAt that would make it
+				// fire on every subsequent tick.
+				continue
+			}
+			entry.NextRunAt = nextRunAt
+		case entry.Interval > 0:
+			entry.NextRunAt = now.Add(entry.Interval)
+		default:
+			continue
+		}
+		entry.LockedBy = ""
+		entry.LockedUntil = time.Time{}
+
+		_ = s.store.UpsertSchedule(ctx, entry)
+	}
+}
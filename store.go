@@ -7,6 +7,7 @@ package jobqueue
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var (
@@ -37,6 +38,10 @@ type Store interface {
 	// The store should take the job priorities into account when picking the
 	// next job. Jobs with higher priorities should be executed first.
 	//
+	// Jobs whose NextRetryAt is set to a time in the future must be skipped,
+	// even if they would otherwise be picked; they become eligible again
+	// once NextRetryAt has passed.
+	//
 	// If no job is ready to be executed, e.g. the job queue is idle, the
 	// store must return nil for both the job and the error.
 	Next() (*Job, error)
@@ -56,6 +61,29 @@ type Store interface {
 
 	// List returns a list of jobs filtered by the ListRequest.
 	List(context.Context, *ListRequest) (*ListResponse, error)
+
+	// NextScheduled returns the schedules that are due to fire at or before
+	// now, i.e. ScheduleEntry.NextRunAt <= now and the entry is not locked by
+	// another manager. Implementations must take the lock on the returned
+	// entries, e.g. via a row lock, so that only one manager in a cluster
+	// fires a given schedule at a given time.
+	NextScheduled(now time.Time) ([]*ScheduleEntry, error)
+
+	// UpsertSchedule creates or updates a recurring schedule, e.g. to set
+	// its next run time and release its lock after firing.
+	UpsertSchedule(context.Context, *ScheduleEntry) error
+
+	// ListSchedules returns all recurring schedules known to the store.
+	ListSchedules(context.Context) ([]*ScheduleEntry, error)
+
+	// AppendHistory records a single, immutable state transition for a job.
+	// Implementations must key rows by (JobID, Version) and must never
+	// update or delete an existing row.
+	AppendHistory(context.Context, *JobEvent) error
+
+	// History returns the ordered state transitions recorded for jobID,
+	// oldest first.
+	History(ctx context.Context, jobID string) ([]*JobEvent, error)
 }
 
 // StatsRequest returns information about the number of managed jobs.